@@ -0,0 +1,328 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2023 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dmverity
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// HashAlgorithm identifies a digest algorithm used to build a dm-verity
+// hash tree.
+type HashAlgorithm string
+
+const (
+	// SHA256 is the default dm-verity hash algorithm.
+	SHA256 HashAlgorithm = "sha256"
+	// SHA512 is the other dm-verity hash algorithm exposed by gVisor's
+	// verity file system.
+	SHA512 HashAlgorithm = "sha512"
+)
+
+// newHash returns a fresh hash.Hash for alg, or an error if alg is not
+// supported.
+func newHash(alg HashAlgorithm) (hash.Hash, error) {
+	switch alg {
+	case SHA256, "":
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported dm-verity hash algorithm %q", alg)
+	}
+}
+
+// digestSize returns the digest size, in bytes, produced by alg.
+func digestSize(alg HashAlgorithm) (int, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return 0, err
+	}
+	return h.Size(), nil
+}
+
+const (
+	// defaultBlockSize is the data and hash block size used by
+	// MerkleTree and ComputeAndWriteHashDevice, matching veritysetup's
+	// default. FormatWithOptions allows overriding it.
+	defaultBlockSize = 4096
+
+	superBlockSize = 512
+
+	// verityMagic is the on-disk super block signature, null-padded out
+	// to 8 bytes to match veritysetup's struct verity_sb.
+	verityMagic   = "verity\x00\x00"
+	verityVersion = 1
+	// verityHashType 1 is the "normal" dm-verity hash type (as opposed
+	// to the Chrome OS specific type 0).
+	verityHashType = 1
+
+	// Offsets of the fields of the on-disk dm-verity super block (see
+	// Documentation/admin-guide/device-mapper/verity.rst and
+	// veritysetup's struct verity_sb), which is laid out as:
+	//
+	//	uint8_t  signature[8];
+	//	uint32_t version;
+	//	uint32_t hash_type;
+	//	uint8_t  uuid[16];
+	//	uint8_t  algorithm[32];
+	//	uint32_t data_block_size;
+	//	uint32_t hash_block_size;
+	//	uint64_t data_blocks;
+	//	uint16_t salt_size;
+	//	uint8_t  _pad1[6];
+	//	uint8_t  salt[256];
+	//	uint8_t  _pad2[168];
+	sbSignatureOff     = 0
+	sbVersionOff       = 8
+	sbHashTypeOff      = 12
+	sbUUIDOff          = 16
+	sbAlgorithmOff     = 32
+	sbDataBlockSizeOff = 64
+	sbHashBlockSizeOff = 68
+	sbDataBlocksOff    = 72
+	sbSaltSizeOff      = 80
+	sbSaltOff          = 88
+	sbSaltFieldSize    = 256
+)
+
+// hashBlock hashes salt||data with alg and returns the raw digest.
+func hashBlock(alg HashAlgorithm, salt []byte, data []byte) ([]byte, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(salt)
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// readBlock reads exactly blockSize bytes from r, zero-padding a final
+// short read. It returns io.EOF once there is no more data at all.
+func readBlock(r io.Reader, blockSize int) ([]byte, error) {
+	buf := make([]byte, blockSize)
+	n, err := io.ReadFull(r, buf)
+	if n == 0 {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		for i := n; i < blockSize; i++ {
+			buf[i] = 0
+		}
+		return buf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// packLevel packs digests into blockSize, zero-padded hash blocks,
+// digestsPerBlock digests to a block.
+func packLevel(digests [][]byte, digestSize, blockSize int) [][]byte {
+	perBlock := blockSize / digestSize
+	var blocks [][]byte
+	for i := 0; i < len(digests); i += perBlock {
+		end := i + perBlock
+		if end > len(digests) {
+			end = len(digests)
+		}
+		block := make([]byte, blockSize)
+		off := 0
+		for _, d := range digests[i:end] {
+			copy(block[off:], d)
+			off += digestSize
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// buildTree hashes the data blocks read from r into level-0 digests and
+// then repeatedly packs and hashes each level until a single hash block
+// remains at the top. It returns every level's hash blocks, ordered from
+// the bottom (level 0, closest to the data) to the top, along with the
+// root digest (the hash of the single top-level block). Input that fits
+// in a single data block has no hash levels: its root digest is the
+// digest of that data block, as in the kernel's dm-verity target.
+func buildTree(r io.Reader, salt []byte, alg HashAlgorithm, dataBlockSize, hashBlockSize int) (levels [][][]byte, root []byte, err error) {
+	digestSize, err := digestSize(alg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var digests [][]byte
+	for {
+		block, err := readBlock(r, dataBlockSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		digest, err := hashBlock(alg, salt, block)
+		if err != nil {
+			return nil, nil, err
+		}
+		digests = append(digests, digest)
+	}
+	if len(digests) == 0 {
+		return nil, nil, fmt.Errorf("cannot build a dm-verity hash tree from empty input")
+	}
+	if len(digests) == 1 {
+		return nil, digests[0], nil
+	}
+
+	for {
+		blocks := packLevel(digests, digestSize, hashBlockSize)
+		levels = append(levels, blocks)
+
+		if len(blocks) == 1 {
+			root, err = hashBlock(alg, salt, blocks[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			return levels, root, nil
+		}
+
+		next := make([][]byte, 0, len(blocks))
+		for _, block := range blocks {
+			digest, err := hashBlock(alg, salt, block)
+			if err != nil {
+				return nil, nil, err
+			}
+			next = append(next, digest)
+		}
+		digests = next
+	}
+}
+
+// MerkleTree reads the data blocks from r, hashes them with alg and salt
+// and returns the root digest of the resulting dm-verity hash tree.
+//
+// The tree is built bottom up: each 4096-byte data block is hashed to
+// form a level-0 digest, digests are packed into 4096-byte blocks
+// (zero-padded) which are in turn hashed to form the next level, and so
+// on until a single block remains at the top. The root digest is the
+// hash of that top-level block. When r holds exactly one data block, the
+// root digest is the digest of that data block.
+func MerkleTree(r io.Reader, salt []byte, alg HashAlgorithm) ([]byte, error) {
+	_, root, err := buildTree(r, salt, alg, defaultBlockSize, defaultBlockSize)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// writeSuperBlock serializes a dm-verity v1 superblock for the given
+// parameters to w, matching veritysetup's on-disk struct verity_sb byte
+// for byte, so that hash devices produced here are interchangeable with
+// ones produced by the real veritysetup binary.
+func writeSuperBlock(w io.Writer, alg HashAlgorithm, uuid [16]byte, dataBlockSize, hashBlockSize uint32, dataBlocks uint64, salt []byte) error {
+	if len(salt) > sbSaltFieldSize {
+		return fmt.Errorf("dm-verity salt of %d bytes does not fit in the %d byte super block salt field", len(salt), sbSaltFieldSize)
+	}
+
+	buf := make([]byte, superBlockSize)
+
+	copy(buf[sbSignatureOff:sbVersionOff], verityMagic)
+	binary.LittleEndian.PutUint32(buf[sbVersionOff:sbHashTypeOff], verityVersion)
+	binary.LittleEndian.PutUint32(buf[sbHashTypeOff:sbUUIDOff], verityHashType)
+	copy(buf[sbUUIDOff:sbAlgorithmOff], uuid[:])
+	copy(buf[sbAlgorithmOff:sbDataBlockSizeOff], []byte(alg))
+	binary.LittleEndian.PutUint32(buf[sbDataBlockSizeOff:sbHashBlockSizeOff], dataBlockSize)
+	binary.LittleEndian.PutUint32(buf[sbHashBlockSizeOff:sbDataBlocksOff], hashBlockSize)
+	binary.LittleEndian.PutUint64(buf[sbDataBlocksOff:sbSaltSizeOff], dataBlocks)
+	binary.LittleEndian.PutUint16(buf[sbSaltSizeOff:sbSaltOff], uint16(len(salt)))
+	copy(buf[sbSaltOff:sbSaltOff+len(salt)], salt)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ComputeAndWriteHashDevice builds a dm-verity hash tree for the data in
+// r and writes a dm-verity-compatible hash device (superblock, zero-padded
+// to a hash block boundary, followed by the tree, written top level
+// first) to w. It returns an Info
+// describing the resulting root hash, using the same defaults (SHA256,
+// a random salt, 4096-byte blocks) as FormatWithOptions with nil
+// options.
+func ComputeAndWriteHashDevice(r io.ReadSeeker, w io.Writer) (*Info, error) {
+	opts, err := (&FormatOptions{}).defaults()
+	if err != nil {
+		return nil, err
+	}
+	return computeAndWriteHashDevice(r, w, opts)
+}
+
+// computeAndWriteHashDevice is the options-aware implementation shared by
+// ComputeAndWriteHashDevice and FormatWithOptions.
+func computeAndWriteHashDevice(r io.ReadSeeker, w io.Writer, opts *FormatOptions) (*Info, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	dataBlocks := uint64((size + int64(opts.DataBlockSize) - 1) / int64(opts.DataBlockSize))
+
+	levels, root, err := buildTree(r, opts.Salt, opts.HashAlgorithm, int(opts.DataBlockSize), int(opts.HashBlockSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeSuperBlock(w, opts.HashAlgorithm, *opts.UUID, opts.DataBlockSize, opts.HashBlockSize, dataBlocks, opts.Salt); err != nil {
+		return nil, err
+	}
+	// The tree starts at the first hash block boundary after the super
+	// block, where veritysetup and the kernel expect it.
+	hashBlockSize := int(opts.HashBlockSize)
+	if pad := (hashBlockSize - superBlockSize%hashBlockSize) % hashBlockSize; pad > 0 {
+		if _, err := w.Write(make([]byte, pad)); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := len(levels) - 1; i >= 0; i-- {
+		for _, block := range levels[i] {
+			if _, err := w.Write(block); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Info{
+		RootHash:      hex.EncodeToString(root),
+		HashAlgorithm: opts.HashAlgorithm,
+		Salt:          opts.Salt,
+		DataBlockSize: opts.DataBlockSize,
+		HashBlockSize: opts.HashBlockSize,
+		UUID:          *opts.UUID,
+	}, nil
+}
@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2023 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dmverity
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+)
+
+// FormatOptions customizes how FormatWithOptions builds a dm-verity hash
+// device, mirroring the options veritysetup accepts on its "format"
+// command line.
+type FormatOptions struct {
+	// HashAlgorithm is the digest algorithm used to build the hash
+	// tree. Defaults to SHA256.
+	HashAlgorithm HashAlgorithm
+	// Salt is mixed into every block digest. Defaults to a random
+	// value; pass a fixed value (e.g. all-zero) for reproducible
+	// builds.
+	Salt []byte
+	// DataBlockSize and HashBlockSize default to 4096, the only sizes
+	// the kernel's dm-verity target currently requires. Both must be a
+	// non-zero power of two, and HashBlockSize must be large enough to
+	// hold at least one digest of HashAlgorithm.
+	DataBlockSize uint32
+	HashBlockSize uint32
+	// UUID is recorded in the super block. Defaults to a random value;
+	// pass a pointer to a fixed value (e.g. all-zero) for reproducible
+	// builds, the same way Salt does.
+	UUID *[16]byte
+
+	// UseVeritysetupBinary makes FormatWithOptions exec "veritysetup
+	// format" instead of building the hash tree in pure Go. The other
+	// FormatOptions fields are still validated but not passed to
+	// veritysetup in that case: veritysetup always uses its own defaults.
+	UseVeritysetupBinary bool
+}
+
+// defaults fills in zero-valued fields of opts with veritysetup's
+// defaults, returning a new, validated FormatOptions.
+func (opts *FormatOptions) defaults() (*FormatOptions, error) {
+	out := *opts
+
+	if out.HashAlgorithm == "" {
+		out.HashAlgorithm = SHA256
+	}
+	if out.DataBlockSize == 0 {
+		out.DataBlockSize = defaultBlockSize
+	}
+	if out.HashBlockSize == 0 {
+		out.HashBlockSize = defaultBlockSize
+	}
+
+	digestSize, err := digestSize(out.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateBlockSize("data", out.DataBlockSize); err != nil {
+		return nil, err
+	}
+	if err := validateBlockSize("hash", out.HashBlockSize); err != nil {
+		return nil, err
+	}
+	if int(out.HashBlockSize) < digestSize {
+		return nil, fmt.Errorf("hash block size %d is too small to hold a %s digest (%d bytes)", out.HashBlockSize, out.HashAlgorithm, digestSize)
+	}
+
+	if out.Salt == nil {
+		salt := make([]byte, digestSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		out.Salt = salt
+	}
+	if out.UUID == nil {
+		var uuid [16]byte
+		if _, err := rand.Read(uuid[:]); err != nil {
+			return nil, err
+		}
+		out.UUID = &uuid
+	}
+
+	return &out, nil
+}
+
+// validateBlockSize checks that size is a non-zero power of two, as
+// required by the kernel's dm-verity target.
+func validateBlockSize(name string, size uint32) error {
+	if size == 0 || size&(size-1) != 0 {
+		return fmt.Errorf("%s block size %d is not a non-zero power of two", name, size)
+	}
+	return nil
+}
+
+// FormatWithOptions builds the dm-verity hash tree for dataDevice and
+// writes it to hashDevice, like Format, but lets the caller control the
+// hash algorithm, salt, block sizes and UUID that go into it.
+func FormatWithOptions(dataDevice string, hashDevice string, opts *FormatOptions) (*Info, error) {
+	if opts == nil {
+		opts = &FormatOptions{}
+	}
+	opts, err := opts.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("cannot prepare dm-verity format options: %v", err)
+	}
+
+	if opts.UseVeritysetupBinary {
+		return formatWithVeritysetupBinary(dataDevice, hashDevice)
+	}
+
+	in, err := os.Open(dataDevice)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(hashDevice)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	return computeAndWriteHashDevice(in, out, opts)
+}
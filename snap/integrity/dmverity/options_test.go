@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2023 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dmverity_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/snap/integrity/dmverity"
+)
+
+type optionsSuite struct{}
+
+var _ = Suite(&optionsSuite{})
+
+// TestFormatWithOptionsRejectsHashBlockSizeSmallerThanDigest checks that
+// a HashBlockSize too small to hold a single digest is rejected with an
+// error, instead of sending packLevel into an infinite loop.
+func (s *optionsSuite) TestFormatWithOptionsRejectsHashBlockSizeSmallerThanDigest(c *C) {
+	dataDevice := filepath.Join(c.MkDir(), "data")
+	hashDevice := filepath.Join(c.MkDir(), "hash")
+	c.Assert(os.WriteFile(dataDevice, []byte("hello"), 0644), IsNil)
+
+	_, err := dmverity.FormatWithOptions(dataDevice, hashDevice, &dmverity.FormatOptions{
+		HashBlockSize: 16,
+	})
+	c.Assert(err, ErrorMatches, ".*hash block size 16 is too small.*")
+}
+
+// TestFormatWithOptionsHonoursExplicitZeroUUID checks that, like Salt, an
+// explicitly provided UUID is never overridden by a random one, even when
+// the caller's UUID happens to be the all-zero value (as reproducible
+// builds need).
+func (s *optionsSuite) TestFormatWithOptionsHonoursExplicitZeroUUID(c *C) {
+	dataDevice := filepath.Join(c.MkDir(), "data")
+	hashDevice := filepath.Join(c.MkDir(), "hash")
+	c.Assert(os.WriteFile(dataDevice, []byte("hello"), 0644), IsNil)
+
+	var zeroUUID [16]byte
+	info, err := dmverity.FormatWithOptions(dataDevice, hashDevice, &dmverity.FormatOptions{
+		UUID: &zeroUUID,
+	})
+	c.Assert(err, IsNil)
+	c.Check(info.UUID, Equals, zeroUUID)
+}
+
+// TestFormatWithOptionsRejectsNonPowerOfTwoBlockSize checks that block
+// sizes that are not a power of two are rejected.
+func (s *optionsSuite) TestFormatWithOptionsRejectsNonPowerOfTwoBlockSize(c *C) {
+	dataDevice := filepath.Join(c.MkDir(), "data")
+	hashDevice := filepath.Join(c.MkDir(), "hash")
+	c.Assert(os.WriteFile(dataDevice, []byte("hello"), 0644), IsNil)
+
+	_, err := dmverity.FormatWithOptions(dataDevice, hashDevice, &dmverity.FormatOptions{
+		DataBlockSize: 4097,
+	})
+	c.Assert(err, ErrorMatches, ".*not a non-zero power of two.*")
+}
@@ -0,0 +1,88 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2023 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dmverity
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/snapcore/snapd/asserts"
+)
+
+// AssertionType is the dm-verity assertion type used to sign and verify
+// Info envelopes. Unlike a caller-supplied assertion type, it is the one
+// kind of assertion this package's envelope format actually understands
+// the headers of ("authority-id", "root-hash"); db must know about it
+// (and about the signing authority's key) for SignInfo and
+// OpenSignedInfo to work.
+var AssertionType = &asserts.AssertionType{Name: "dm-verity"}
+
+// SignInfo signs info as a new dm-verity assertion and returns its
+// serialized encoding, ready to be written out as a ".verity" sidecar
+// file next to a snap's dm-verity hash device. keyID identifies which of
+// db's keys to sign it with, the same way other snapd subsystems sign
+// assertions through asserts.Database.Sign. Whoever later calls
+// OpenSignedInfo needs a trusted asserts.Database that already knows
+// about the signing authority's key.
+func SignInfo(info *Info, db *asserts.Database, authorityID, keyID string) ([]byte, error) {
+	body, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]interface{}{
+		"authority-id": authorityID,
+		"root-hash":    info.RootHash,
+	}
+
+	a, err := db.Sign(AssertionType, headers, body, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign dm-verity info: %v", err)
+	}
+
+	return asserts.Encode(a), nil
+}
+
+// OpenSignedInfo decodes the assertion in blob, checks that it is a
+// dm-verity assertion whose signature and authority are trusted, and
+// returns the Info it carries. Only once this succeeds should a caller
+// go on to activate the dm-verity mapping described by the returned Info
+// in the kernel.
+func OpenSignedInfo(blob []byte, trusted *asserts.Database) (*Info, error) {
+	a, err := asserts.Decode(blob)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode dm-verity assertion: %v", err)
+	}
+
+	if a.Type().Name != AssertionType.Name {
+		return nil, fmt.Errorf("unexpected assertion type %q, expected %q", a.Type().Name, AssertionType.Name)
+	}
+
+	if err := trusted.Check(a); err != nil {
+		return nil, fmt.Errorf("cannot verify dm-verity assertion: %v", err)
+	}
+
+	var info Info
+	if err := json.Unmarshal(a.Body(), &info); err != nil {
+		return nil, fmt.Errorf("cannot decode dm-verity info: %v", err)
+	}
+
+	return &info, nil
+}
@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2023 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dmverity_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+	"github.com/snapcore/snapd/snap/integrity/dmverity"
+)
+
+type signedInfoSuite struct {
+	authorityID string
+	privKey     asserts.PrivateKey
+	db          *asserts.Database
+}
+
+var _ = Suite(&signedInfoSuite{})
+
+func (s *signedInfoSuite) SetUpTest(c *C) {
+	s.authorityID = "canonical"
+
+	privKey, err := assertstest.GenerateKey(752)
+	c.Assert(err, IsNil)
+	s.privKey = privKey
+
+	db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
+		Backstore: asserts.NewMemoryBackstore(),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(db.ImportKey(s.privKey), IsNil)
+	s.db = db
+}
+
+func (s *signedInfoSuite) keyID() string {
+	return s.privKey.PublicKey().ID()
+}
+
+// TestSignInfoOpenSignedInfoRoundTrip checks that an Info signed with
+// SignInfo can be recovered unchanged through OpenSignedInfo against a
+// database that holds the signing key.
+func (s *signedInfoSuite) TestSignInfoOpenSignedInfoRoundTrip(c *C) {
+	info := &dmverity.Info{
+		RootHash:      "deadbeef",
+		HashAlgorithm: dmverity.SHA256,
+		Salt:          []byte{1, 2, 3},
+		DataBlockSize: 4096,
+		HashBlockSize: 4096,
+	}
+
+	blob, err := dmverity.SignInfo(info, s.db, s.authorityID, s.keyID())
+	c.Assert(err, IsNil)
+
+	got, err := dmverity.OpenSignedInfo(blob, s.db)
+	c.Assert(err, IsNil)
+	c.Check(got, DeepEquals, info)
+}
+
+// TestOpenSignedInfoRejectsTamperedSignature checks that flipping a byte
+// of a signed blob (which, for an encoded assertion, always lands either
+// in the signed content or in the signature itself) is caught instead of
+// silently accepted.
+func (s *signedInfoSuite) TestOpenSignedInfoRejectsTamperedSignature(c *C) {
+	info := &dmverity.Info{RootHash: "deadbeef"}
+
+	blob, err := dmverity.SignInfo(info, s.db, s.authorityID, s.keyID())
+	c.Assert(err, IsNil)
+
+	tampered := append([]byte(nil), blob...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	_, err = dmverity.OpenSignedInfo(tampered, s.db)
+	c.Assert(err, NotNil)
+}
+
+// TestOpenSignedInfoRejectsUntrustedAuthority checks that a database
+// which never imported the signing key rejects the assertion, instead of
+// trusting any syntactically valid dm-verity assertion.
+func (s *signedInfoSuite) TestOpenSignedInfoRejectsUntrustedAuthority(c *C) {
+	info := &dmverity.Info{RootHash: "deadbeef"}
+
+	blob, err := dmverity.SignInfo(info, s.db, s.authorityID, s.keyID())
+	c.Assert(err, IsNil)
+
+	untrusted, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
+		Backstore: asserts.NewMemoryBackstore(),
+	})
+	c.Assert(err, IsNil)
+
+	_, err = dmverity.OpenSignedInfo(blob, untrusted)
+	c.Assert(err, ErrorMatches, "cannot verify dm-verity assertion:.*")
+}
+
+// TestOpenSignedInfoRejectsGarbage checks that a blob which isn't even a
+// well-formed assertion is rejected with a decode error before any
+// signature checking is attempted.
+func (s *signedInfoSuite) TestOpenSignedInfoRejectsGarbage(c *C) {
+	_, err := dmverity.OpenSignedInfo([]byte("not an assertion"), s.db)
+	c.Assert(err, ErrorMatches, "cannot decode dm-verity assertion:.*")
+}
@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2023 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dmverity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotVeritySuperBlock is returned by ReadSuperBlock when the leading
+// bytes of the hash device do not carry the dm-verity magic signature.
+var ErrNotVeritySuperBlock = errors.New("not a dm-verity super block")
+
+// SuperBlock holds the fields of an on-disk dm-verity superblock, as
+// written by veritysetup or writeSuperBlock.
+type SuperBlock struct {
+	// Signature is the 8 byte magic, always "verity" null-padded to 8
+	// bytes.
+	Signature string
+	Version   uint32
+	HashType  uint32
+	UUID      [16]byte
+	Algorithm HashAlgorithm
+
+	DataBlockSize uint32
+	HashBlockSize uint32
+	DataBlocks    uint64
+
+	SaltSize uint16
+	Salt     []byte
+}
+
+// ReadSuperBlock reads and parses the dm-verity superblock at the start
+// of hashDevice.
+func ReadSuperBlock(hashDevice string) (*SuperBlock, error) {
+	f, err := os.Open(hashDevice)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, superBlockSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, fmt.Errorf("cannot read dm-verity super block: %v", err)
+	}
+
+	if !bytes.Equal(buf[sbSignatureOff:sbVersionOff], []byte(verityMagic)) {
+		return nil, ErrNotVeritySuperBlock
+	}
+
+	sb := &SuperBlock{
+		Signature: verityMagic,
+		Version:   binary.LittleEndian.Uint32(buf[sbVersionOff:sbHashTypeOff]),
+		HashType:  binary.LittleEndian.Uint32(buf[sbHashTypeOff:sbUUIDOff]),
+	}
+	copy(sb.UUID[:], buf[sbUUIDOff:sbAlgorithmOff])
+	sb.Algorithm = HashAlgorithm(bytes.TrimRight(buf[sbAlgorithmOff:sbDataBlockSizeOff], "\x00"))
+	sb.DataBlockSize = binary.LittleEndian.Uint32(buf[sbDataBlockSizeOff:sbHashBlockSizeOff])
+	sb.HashBlockSize = binary.LittleEndian.Uint32(buf[sbHashBlockSizeOff:sbDataBlocksOff])
+	sb.DataBlocks = binary.LittleEndian.Uint64(buf[sbDataBlocksOff:sbSaltSizeOff])
+	sb.SaltSize = binary.LittleEndian.Uint16(buf[sbSaltSizeOff:sbSaltOff])
+
+	if int(sb.SaltSize) > sbSaltFieldSize {
+		return nil, fmt.Errorf("dm-verity super block salt size %d exceeds the %d byte salt field", sb.SaltSize, sbSaltFieldSize)
+	}
+	sb.Salt = append([]byte(nil), buf[sbSaltOff:sbSaltOff+int(sb.SaltSize)]...)
+
+	return sb, nil
+}
+
+// Verify recomputes the dm-verity root hash of dataDevice, using the hash
+// algorithm, salt and block sizes recorded in hashDevice's super block,
+// and confirms that it matches info.RootHash. The hash tree stored in
+// hashDevice is not read or compared.
+func (info *Info) Verify(dataDevice, hashDevice string) error {
+	sb, err := ReadSuperBlock(hashDevice)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.Open(dataDevice)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	_, root, err := buildTree(data, sb.Salt, sb.Algorithm, int(sb.DataBlockSize), int(sb.HashBlockSize))
+	if err != nil {
+		return fmt.Errorf("cannot recompute dm-verity root hash: %v", err)
+	}
+
+	rootHash := hex.EncodeToString(root)
+	if rootHash != info.RootHash {
+		return fmt.Errorf("dm-verity root hash mismatch: expected %s, got %s", info.RootHash, rootHash)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2023 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dmverity_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/snap/integrity/dmverity"
+)
+
+type superBlockSuite struct{}
+
+var _ = Suite(&superBlockSuite{})
+
+// TestReadSuperBlockRejectsCorruptSaltSize checks that a corrupted or
+// adversarial salt_size field is reported as a parse error instead of
+// panicking with a slice-bounds-out-of-range.
+func (s *superBlockSuite) TestReadSuperBlockRejectsCorruptSaltSize(c *C) {
+	hashDevice := filepath.Join(c.MkDir(), "hash")
+
+	buf := make([]byte, 512)
+	copy(buf[0:6], []byte("verity"))
+	binary.LittleEndian.PutUint16(buf[80:82], 65000)
+	c.Assert(os.WriteFile(hashDevice, buf, 0644), IsNil)
+
+	_, err := dmverity.ReadSuperBlock(hashDevice)
+	c.Assert(err, ErrorMatches, ".*salt size.*exceeds.*")
+}
+
+// TestWriteSuperBlockMatchesVeritysetupLayout checks the on-disk bytes of
+// a freshly formatted hash device against a hand-built super block,
+// field offset by field offset, so that a future change cannot silently
+// drift away from veritysetup's real struct verity_sb layout while still
+// passing every other test here (which only round-trip against this
+// package's own reader).
+func (s *superBlockSuite) TestWriteSuperBlockMatchesVeritysetupLayout(c *C) {
+	dataDevice := filepath.Join(c.MkDir(), "data")
+	hashDevice := filepath.Join(c.MkDir(), "hash")
+	c.Assert(os.WriteFile(dataDevice, []byte("hi"), 0644), IsNil)
+
+	salt := []byte{0xaa, 0xbb, 0xcc}
+	var uuid [16]byte
+	for i := range uuid {
+		uuid[i] = byte(i + 1)
+	}
+
+	_, err := dmverity.FormatWithOptions(dataDevice, hashDevice, &dmverity.FormatOptions{
+		Salt: salt,
+		UUID: &uuid,
+	})
+	c.Assert(err, IsNil)
+
+	got, err := os.ReadFile(hashDevice)
+	c.Assert(err, IsNil)
+	c.Assert(len(got) >= 512, Equals, true)
+	got = got[:512]
+
+	want := make([]byte, 512)
+	copy(want[0:8], "verity\x00\x00")
+	binary.LittleEndian.PutUint32(want[8:12], 1)  // version
+	binary.LittleEndian.PutUint32(want[12:16], 1) // hash_type
+	copy(want[16:32], uuid[:])
+	copy(want[32:64], "sha256")
+	binary.LittleEndian.PutUint32(want[64:68], 4096) // data_block_size
+	binary.LittleEndian.PutUint32(want[68:72], 4096) // hash_block_size
+	binary.LittleEndian.PutUint64(want[72:80], 1)    // data_blocks
+	binary.LittleEndian.PutUint16(want[80:82], uint16(len(salt)))
+	// want[82:88] is the 6 byte padding gap before the salt field,
+	// left zero.
+	copy(want[88:88+len(salt)], salt)
+	// want[88+len(salt):344] is the rest of the fixed 256 byte salt
+	// field, want[344:512] is the trailing padding, both left zero.
+
+	c.Assert(got, DeepEquals, want)
+}
+
+// TestVerifyWithNonDefaultBlockSizes checks that Verify recomputes the
+// root hash using the data/hash block sizes recorded in the super block,
+// rather than always assuming the default 4096-byte blocks.
+func (s *superBlockSuite) TestVerifyWithNonDefaultBlockSizes(c *C) {
+	dataDevice := filepath.Join(c.MkDir(), "data")
+	hashDevice := filepath.Join(c.MkDir(), "hash")
+	c.Assert(os.WriteFile(dataDevice, bytes.Repeat([]byte("x"), 20000), 0644), IsNil)
+
+	info, err := dmverity.FormatWithOptions(dataDevice, hashDevice, &dmverity.FormatOptions{
+		DataBlockSize: 8192,
+		HashBlockSize: 8192,
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(info.Verify(dataDevice, hashDevice), IsNil)
+}
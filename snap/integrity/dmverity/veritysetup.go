@@ -36,9 +36,18 @@ import (
 // 2. need their authenticity verified prior to loading the integrity data into the
 //    kernel.
 //
-// For now, since we are keeping the superblock as it is, this only includes the root hash.
+// Besides the root hash, Info also records the parameters that were used
+// to derive it (algorithm, salt, block sizes, uuid), since the root hash
+// alone is not enough to reconstruct the kernel dm-verity target line or
+// to rebuild the same hash device from the same input.
 type Info struct {
 	RootHash string `json:"root-hash"`
+
+	HashAlgorithm HashAlgorithm `json:"hash-algorithm,omitempty"`
+	Salt          []byte        `json:"salt,omitempty"`
+	DataBlockSize uint32        `json:"data-block-size,omitempty"`
+	HashBlockSize uint32        `json:"hash-block-size,omitempty"`
+	UUID          [16]byte      `json:"uuid,omitempty"`
 }
 
 func getRootHashFromOutput(output []byte) (rootHash string, err error) {
@@ -62,11 +71,21 @@ func getRootHashFromOutput(output []byte) (rootHash string, err error) {
 	return rootHash, nil
 }
 
-// Format runs "veritysetup format" and returns an Info struct which includes the
-// root hash. "veritysetup format" calculates the hash verification data for
-// dataDevice and stores them in hashDevice. The root hash is retrieved from
-// the command's stdout.
+// Format calculates the hash verification data for dataDevice and stores
+// them in hashDevice, returning an Info struct which includes the root
+// hash. It is equivalent to calling FormatWithOptions with nil options:
+// the hash tree is built in pure Go, with veritysetup's defaults (SHA256,
+// a random salt, 4096-byte blocks). Callers that need to exec the
+// "veritysetup format" binary instead (e.g. for behaviour the pure-Go
+// builder does not support yet) should use FormatWithOptions with
+// FormatOptions.UseVeritysetupBinary set.
 func Format(dataDevice string, hashDevice string) (*Info, error) {
+	return FormatWithOptions(dataDevice, hashDevice, nil)
+}
+
+// formatWithVeritysetupBinary shells out to "veritysetup format" and
+// parses its stdout to recover the root hash.
+func formatWithVeritysetupBinary(dataDevice, hashDevice string) (*Info, error) {
 	cmd := exec.Command("veritysetup", "format", dataDevice, hashDevice)
 
 	output, err := cmd.CombinedOutput()
@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2023 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dmverity_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/snap/integrity/dmverity"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type veritysetupSuite struct{}
+
+var _ = Suite(&veritysetupSuite{})
+
+// TestFormatMatchesFormatWithOptionsDefaults checks that Format() is a
+// thin wrapper around FormatWithOptions(dataDevice, hashDevice, nil),
+// rather than a second, diverging default implementation.
+func (s *veritysetupSuite) TestFormatMatchesFormatWithOptionsDefaults(c *C) {
+	dataDevice := filepath.Join(c.MkDir(), "data")
+	hashDevice := filepath.Join(c.MkDir(), "hash")
+	c.Assert(os.WriteFile(dataDevice, []byte("hello dm-verity"), 0644), IsNil)
+
+	info, err := dmverity.Format(dataDevice, hashDevice)
+	c.Assert(err, IsNil)
+	c.Check(info.HashAlgorithm, Equals, dmverity.SHA256)
+	c.Check(info.DataBlockSize, Equals, uint32(4096))
+	c.Check(info.HashBlockSize, Equals, uint32(4096))
+	c.Check(len(info.Salt), Equals, 32)
+	c.Check(info.Salt, Not(DeepEquals), make([]byte, 32))
+
+	c.Assert(info.Verify(dataDevice, hashDevice), IsNil)
+}
+
+// TestFormatWithOptionsUsesVeritysetupBinaryWhenRequested checks that
+// FormatOptions.UseVeritysetupBinary is a reachable way to opt into
+// exec'ing the veritysetup binary.
+func (s *veritysetupSuite) TestFormatWithOptionsUsesVeritysetupBinaryWhenRequested(c *C) {
+	cmd := testutil.MockCommand(c, "veritysetup", `echo "Root hash: deadbeef"`)
+	defer cmd.Restore()
+
+	dataDevice := filepath.Join(c.MkDir(), "data")
+	hashDevice := filepath.Join(c.MkDir(), "hash")
+	c.Assert(os.WriteFile(dataDevice, []byte("hello"), 0644), IsNil)
+
+	info, err := dmverity.FormatWithOptions(dataDevice, hashDevice, &dmverity.FormatOptions{
+		UseVeritysetupBinary: true,
+	})
+	c.Assert(err, IsNil)
+	c.Check(info.RootHash, Equals, "deadbeef")
+
+	cmd.CheckCalls(c, [][]string{
+		{"veritysetup", "format", dataDevice, hashDevice},
+	})
+}